@@ -0,0 +1,236 @@
+// +build windows
+
+// Package config holds the configuration needed to start a utility VM:
+// option parsing, VHD validation, and the `Config` structure itself. It has
+// no dependency on HCS, so it can be constructed and validated independent
+// of the `client/uvm` package that actually drives HCS with it.
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Microsoft/hcsshim"
+	"github.com/Microsoft/hcsshim/osversion"
+)
+
+const (
+	// DefaultUvmTimeoutSeconds is the default time to wait for utility VM operations
+	DefaultUvmTimeoutSeconds = 5 * 60
+
+	// DefaultVhdxSizeGB is the size of the default sandbox & scratch in GB
+	DefaultVhdxSizeGB = 20
+
+	// DefaultVhdxBlockSizeMB is the block-size for the sandbox/scratch VHDx's this package can create.
+	DefaultVhdxBlockSizeMB = 1
+
+	// minVPCIBuildNumber is the earliest Windows build that supports
+	// assigning VPCI devices to a utility VM at creation time.
+	minVPCIBuildNumber = 18362
+)
+
+// AssignedDeviceIDType identifies the scheme used to address a host device
+// being assigned to the utility VM.
+type AssignedDeviceIDType string
+
+const (
+	// VPCIDeviceIDType identifies a device by its PnP instance ID.
+	VPCIDeviceIDType AssignedDeviceIDType = "vpci-instance-id"
+	// VPCIDeviceIDTypeLegacy identifies a device by the legacy VPCI
+	// location path format.
+	VPCIDeviceIDTypeLegacy AssignedDeviceIDType = "vpci-location-path"
+)
+
+// AssignedDevice describes a host device to be attached to the utility VM
+// at creation time via VPCI, rather than hot-added once the VM is running.
+type AssignedDevice struct {
+	IDType AssignedDeviceIDType // How InstanceID should be interpreted
+	// InstanceID is the host device's PnP instance ID, or its legacy VPCI
+	// location path, depending on IDType.
+	InstanceID string
+	// VirtualFunction is the SR-IOV virtual function index to assign, or 0
+	// if InstanceID already identifies the function to assign.
+	VirtualFunction uint16
+}
+
+// Config is the structure used to configuring a utility VM. There are two ways
+// of starting. Either supply a VHD, or a Kernel+Initrd. For the latter, both
+// must be supplied, and both must be in the same directory.
+//
+// VHD is the priority.
+type Config struct {
+	Options                                        // Configuration options
+	Name               string                      // Name of the utility VM
+	UvmTimeoutSeconds  int                         // How long to wait for the utility VM to respond in seconds
+	Uvm                hcsshim.Container           // The actual container, set once StartUtilityVM succeeds
+	MappedVirtualDisks []hcsshim.MappedVirtualDisk // Data-disks to be attached
+	DriverDisks        []hcsshim.MappedVirtualDisk // Disks of .ko files, attached at boot; their drivers are not installed automatically
+}
+
+// Options is the structure used by a client to define configurable options for a utility VM.
+type Options struct {
+	KirdPath        string           // Path to where kernel/initrd are found (defaults to %PROGRAMFILES%\Linux Containers)
+	TimeoutSeconds  int              // Requested time for the utility VM to respond in seconds (may be over-ridden by environment)
+	BootParameters  string           // Additional boot parameters for initrd booting
+	AssignedDevices []AssignedDevice // Host devices to assign to the utility VM at boot
+}
+
+// ParseOptions parses a set of K-V pairs into options used by opengcs. Note
+// for consistency with the LCOW graphdriver in docker, we keep the same
+// convention of an `lcow.` prefix.
+func ParseOptions(options []string) (Options, error) {
+	rOpts := Options{TimeoutSeconds: 0}
+	for _, v := range options {
+		opt := strings.SplitN(v, "=", 2)
+		if len(opt) == 2 {
+			switch strings.ToLower(opt[0]) {
+			case "lcow.kirdpath":
+				rOpts.KirdPath = opt[1]
+			case "lcow.bootparameters":
+				rOpts.BootParameters = opt[1]
+			case "lcow.timeout":
+				var err error
+				if rOpts.TimeoutSeconds, err = strconv.Atoi(opt[1]); err != nil {
+					return rOpts, fmt.Errorf("lcow.timeout option could not be interpreted as an integer")
+				}
+				if rOpts.TimeoutSeconds < 0 {
+					return rOpts, fmt.Errorf("lcow.timeout option cannot be negative")
+				}
+			case "lcow.assigneddevices":
+				devices, err := parseAssignedDevices(opt[1])
+				if err != nil {
+					return rOpts, err
+				}
+				rOpts.AssignedDevices = append(rOpts.AssignedDevices, devices...)
+			}
+		}
+	}
+
+	// Set default values if not supplied
+	if rOpts.KirdPath == "" {
+		rOpts.KirdPath = filepath.Join(os.Getenv("ProgramFiles"), "Linux Containers")
+	}
+	return rOpts, nil
+}
+
+// parseAssignedDevices parses a `;`-separated list of
+// `<idType>:<instanceID>[:<virtualFunction>]` device specs, as supplied via
+// the `lcow.assigneddevices` option.
+func parseAssignedDevices(value string) ([]AssignedDevice, error) {
+	var devices []AssignedDevice
+	for _, spec := range strings.Split(value, ";") {
+		if spec == "" {
+			continue
+		}
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("lcow.assigneddevices entry %q is malformed", spec)
+		}
+
+		var idType AssignedDeviceIDType
+		switch strings.ToLower(parts[0]) {
+		case "vpci-instance-id":
+			idType = VPCIDeviceIDType
+		case "vpci-location-path":
+			idType = VPCIDeviceIDTypeLegacy
+		default:
+			return nil, fmt.Errorf("lcow.assigneddevices entry %q has unknown device ID type %q", spec, parts[0])
+		}
+
+		device := AssignedDevice{IDType: idType, InstanceID: parts[1]}
+		if len(parts) == 3 {
+			vf, err := strconv.ParseUint(parts[2], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("lcow.assigneddevices entry %q has an invalid virtual function index", spec)
+			}
+			device.VirtualFunction = uint16(vf)
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// GenerateDefault generates a default config from a set of options
+// If baseDir is not supplied, defaults to $env:ProgramFiles\Linux Containers
+func (config *Config) GenerateDefault(options []string) error {
+	// Parse the options that the user supplied.
+	var err error
+	config.Options, err = ParseOptions(options)
+	if err != nil {
+		return err
+	}
+
+	// Get the timeout from the environment
+	envTimeoutSeconds := 0
+	envTimeout := os.Getenv("OPENGCS_UVM_TIMEOUT_SECONDS")
+	if len(envTimeout) > 0 {
+		var err error
+		if envTimeoutSeconds, err = strconv.Atoi(envTimeout); err != nil {
+			return fmt.Errorf("OPENGCS_UVM_TIMEOUT_SECONDS could not be interpreted as an integer")
+		}
+		if envTimeoutSeconds < 0 {
+			return fmt.Errorf("OPENGCS_UVM_TIMEOUT_SECONDS cannot be negative")
+		}
+	}
+
+	// Priority to the requested timeout from the options.
+	if config.TimeoutSeconds != 0 {
+		config.UvmTimeoutSeconds = config.TimeoutSeconds
+		return nil
+	}
+
+	// Next priority, the environment
+	if envTimeoutSeconds != 0 {
+		config.UvmTimeoutSeconds = envTimeoutSeconds
+		return nil
+	}
+
+	// Last priority is the default timeout
+	config.UvmTimeoutSeconds = DefaultUvmTimeoutSeconds
+
+	return nil
+}
+
+// Validate validates a Config structure for starting a utility VM.
+func (config *Config) Validate() error {
+
+	if _, err := os.Stat(filepath.Join(config.KirdPath, `kernel`)); os.IsNotExist(err) {
+		return fmt.Errorf("kernel not found in %s", config.KirdPath)
+	}
+	if _, err := os.Stat(filepath.Join(config.KirdPath, `initrd.img`)); os.IsNotExist(err) {
+		return fmt.Errorf("initrd not found in %s", config.KirdPath)
+	}
+
+	// Ensure all the MappedVirtualDisks exist on the host
+	for _, mvd := range config.MappedVirtualDisks {
+		if _, err := os.Stat(mvd.HostPath); err != nil {
+			return fmt.Errorf("mapped virtual disk '%s' not found", mvd.HostPath)
+		}
+		if mvd.ContainerPath == "" {
+			return fmt.Errorf("mapped virtual disk '%s' requested without a container path", mvd.HostPath)
+		}
+	}
+
+	// Ensure all the DriverDisks exist on the host
+	for _, dd := range config.DriverDisks {
+		if _, err := os.Stat(dd.HostPath); err != nil {
+			return fmt.Errorf("driver disk '%s' not found", dd.HostPath)
+		}
+	}
+
+	if len(config.AssignedDevices) > 0 && osversion.Get().Build < minVPCIBuildNumber {
+		return fmt.Errorf("assigned devices require Windows build %d or later", minVPCIBuildNumber)
+	}
+	seenInstanceIDs := make(map[string]struct{})
+	for _, ad := range config.AssignedDevices {
+		if _, ok := seenInstanceIDs[ad.InstanceID]; ok {
+			return fmt.Errorf("assigned device '%s' specified more than once", ad.InstanceID)
+		}
+		seenInstanceIDs[ad.InstanceID] = struct{}{}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+// +build windows
+
+// Package resources tracks the virtual disks mapped into a running utility
+// VM, so that the same host disk can be shared by more than one caller and
+// is only hot-added/hot-removed from the VM on the first/last reference.
+package resources
+
+import (
+	"sync"
+
+	"github.com/Microsoft/hcsshim"
+)
+
+// Manager refcounts the `hcsshim.MappedVirtualDisk`s attached to a utility
+// VM, keyed by host path.
+type Manager struct {
+	mu      sync.Mutex
+	mounted map[string]*mappedDisk
+}
+
+type mappedDisk struct {
+	disk hcsshim.MappedVirtualDisk
+	refs int
+}
+
+// NewManager returns an empty `Manager`.
+func NewManager() *Manager {
+	return &Manager{mounted: make(map[string]*mappedDisk)}
+}
+
+// Add registers `disk` as mapped into the utility VM. It returns true if
+// this is the first reference to `disk.HostPath`, meaning the caller is
+// responsible for actually hot-adding it.
+func (m *Manager) Add(disk hcsshim.MappedVirtualDisk) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if d, ok := m.mounted[disk.HostPath]; ok {
+		d.refs++
+		return false
+	}
+	m.mounted[disk.HostPath] = &mappedDisk{disk: disk, refs: 1}
+	return true
+}
+
+// Remove releases a reference on the disk at `hostPath`. It returns true if
+// this was the last reference, meaning the caller is responsible for
+// actually hot-removing it. It returns false if `hostPath` was not tracked.
+func (m *Manager) Remove(hostPath string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.mounted[hostPath]
+	if !ok {
+		return false
+	}
+	d.refs--
+	if d.refs > 0 {
+		return false
+	}
+	delete(m.mounted, hostPath)
+	return true
+}
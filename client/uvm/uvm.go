@@ -0,0 +1,143 @@
+// +build windows
+
+// Package uvm drives a utility VM through HCS. It depends on
+// `client/config` for the configuration used to create the VM, and on
+// `client/resources` to track disks mapped into it, but is otherwise the
+// only package in this tree that talks to HCS directly.
+package uvm
+
+import (
+	"encoding/json"
+
+	"github.com/Microsoft/hcsshim"
+	"github.com/Microsoft/opengcs/client/config"
+	"github.com/Microsoft/opengcs/client/resources"
+	"github.com/sirupsen/logrus"
+)
+
+// UVM is the interface a utility VM is driven through. Downstream
+// consumers depend on this interface, rather than a concrete HCS-backed
+// type, so they can substitute a mock in tests.
+type UVM interface {
+	// Start creates and starts the utility VM.
+	Start() error
+	// Terminate forcibly tears down the utility VM.
+	Terminate() error
+	// Wait blocks until the utility VM exits.
+	Wait() error
+	// Exec starts a process inside the utility VM.
+	Exec(commandLine string) (hcsshim.Process, error)
+	// Container returns the underlying hcsshim container, for callers that
+	// still need functionality not yet exposed on `UVM`.
+	Container() hcsshim.Container
+}
+
+// hcsUVM is the production `UVM`, backed by a real hcsshim container.
+type hcsUVM struct {
+	cfg       *config.Config
+	resources *resources.Manager
+	container hcsshim.Container
+}
+
+// New creates (but does not start) a utility VM from `cfg`.
+func New(cfg *config.Config) UVM {
+	return &hcsUVM{cfg: cfg, resources: resources.NewManager()}
+}
+
+// Start creates and starts a utility VM from its configuration.
+func (u *hcsUVM) Start() (err error) {
+	cfg := u.cfg
+	logrus.Debugf("opengcs: StartUtilityVM: %+v", cfg)
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	// Driver disks are attached alongside the regular mapped virtual disks
+	// so they're present at boot. Their drivers are installed only by a
+	// guest call to `drivers.Install` for their SCSI controller/LUN; nothing
+	// in this tree makes that call, so they are not installed automatically.
+	var mappedVirtualDisks []hcsshim.MappedVirtualDisk
+	for _, mvd := range append(append([]hcsshim.MappedVirtualDisk{}, cfg.MappedVirtualDisks...), cfg.DriverDisks...) {
+		if u.resources.Add(mvd) {
+			mappedVirtualDisks = append(mappedVirtualDisks, mvd)
+		}
+	}
+
+	configuration := &hcsshim.ContainerConfig{
+		HvPartition:                 true,
+		Name:                        cfg.Name,
+		SystemType:                  "container",
+		ContainerType:               "linux",
+		TerminateOnLastHandleClosed: true,
+		MappedVirtualDisks:          mappedVirtualDisks,
+		AssignedDevices:             toHcsshimAssignedDevices(cfg.AssignedDevices),
+		HvRuntime: &hcsshim.HvRuntime{
+			ImagePath:           cfg.KirdPath,
+			LinuxInitrdFile:     `initrd.img`,
+			LinuxKernelFile:     `kernel`,
+			LinuxBootParameters: cfg.BootParameters,
+		},
+	}
+
+	configurationS, _ := json.Marshal(configuration)
+	logrus.Debugf("opengcs: StartUtilityVM: calling HCS with '%s'", string(configurationS))
+	container, err := hcsshim.CreateContainer(cfg.Name, configuration)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("opengcs: StartUtilityVM: uvm created, starting...")
+	if err := container.Start(); err != nil {
+		logrus.Debugf("opengcs: StartUtilityVM: uvm failed to start: %s", err)
+		// Make sure we don't leave it laying around as it's been created in HCS
+		container.Terminate()
+		return err
+	}
+
+	u.container = container
+	logrus.Debugf("opengcs StartUtilityVM: uvm %s is running", cfg.Name)
+	return nil
+}
+
+// Terminate forcibly tears down the utility VM.
+func (u *hcsUVM) Terminate() error {
+	return u.container.Terminate()
+}
+
+// Wait blocks until the utility VM exits.
+func (u *hcsUVM) Wait() error {
+	return u.container.Wait()
+}
+
+// Exec starts a process inside the utility VM.
+func (u *hcsUVM) Exec(commandLine string) (hcsshim.Process, error) {
+	return u.container.CreateProcess(&hcsshim.ProcessConfig{
+		CommandLine: commandLine,
+	})
+}
+
+// Container returns the underlying hcsshim container.
+func (u *hcsUVM) Container() hcsshim.Container {
+	return u.container
+}
+
+// toHcsshimAssignedDevices translates our `AssignedDevice`s into the form
+// HCS expects for VPCI device assignment at container-creation time.
+func toHcsshimAssignedDevices(devices []config.AssignedDevice) []hcsshim.AssignedDevice {
+	if len(devices) == 0 {
+		return nil
+	}
+	result := make([]hcsshim.AssignedDevice, 0, len(devices))
+	for _, d := range devices {
+		hd := hcsshim.AssignedDevice{
+			InstanceID:      d.InstanceID,
+			VirtualFunction: d.VirtualFunction,
+		}
+		if d.IDType == config.VPCIDeviceIDTypeLegacy {
+			hd.LocationPath = d.InstanceID
+			hd.InstanceID = ""
+		}
+		result = append(result, hd)
+	}
+	return result
+}
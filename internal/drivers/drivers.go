@@ -0,0 +1,156 @@
+// +build linux
+
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Microsoft/opengcs/internal/log"
+	"github.com/Microsoft/opengcs/internal/oc"
+	"github.com/Microsoft/opengcs/internal/storage/scsi"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// modulesDepFile is the dependency manifest shipped alongside the `.ko`
+// files on a driver disk, in the same `<module>: <dep> <dep> ...` format
+// `depmod` produces, but pre-built for the guest kernel so the guest never
+// needs to run `depmod`/`modprobe` (which both expect modules laid out
+// under `lib/modules/<kernel version>/`, not a flat directory).
+const modulesDepFile = "modules.dep"
+
+// mountPathFormat is where a driver disk is mounted while its drivers are
+// being installed.
+const mountPathFormat = "/run/drivers/%d-%d"
+
+// Closer releases the resources backing an installed set of drivers.
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// Install mounts the SCSI device on `controller` index `lun`, which is
+// expected to contain one or more `.ko` files alongside a `modules.dep`
+// generated for them, and installs every driver found on it.
+//
+// The returned `Closer` unmounts and unplugs the disk; it does not unload
+// the installed modules.
+func Install(ctx context.Context, m *scsi.Manager, controller, lun uint8) (_ Closer, err error) {
+	ctx, span := trace.StartSpan(ctx, "drivers::Install")
+	defer span.End()
+	defer func() { oc.SetSpanStatus(span, err) }()
+
+	span.AddAttributes(
+		trace.Int64Attribute("controller", int64(controller)),
+		trace.Int64Attribute("lun", int64(lun)))
+
+	attachment, err := m.Attach(ctx, controller, lun)
+	if err != nil {
+		return nil, errors.Wrap(err, "attaching driver disk")
+	}
+	defer func() {
+		if err != nil {
+			attachment.Close(ctx)
+		}
+	}()
+
+	target := fmt.Sprintf(mountPathFormat, controller, lun)
+	mnt, err := attachment.Mount(ctx, target, scsi.MountConfig{ReadOnly: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "mounting driver disk")
+	}
+	defer func() {
+		if err != nil {
+			mnt.Close(ctx)
+		}
+	}()
+
+	if err := install(ctx, target); err != nil {
+		return nil, err
+	}
+
+	return &closer{attachment: attachment, mount: mnt}, nil
+}
+
+// install `insmod`s every `.ko` under `path`, in the dependency order given
+// by the `modules.dep` shipped alongside them.
+func install(ctx context.Context, path string) error {
+	deps, err := parseModulesDep(path)
+	if err != nil {
+		return errors.Wrap(err, "parsing modules.dep")
+	}
+
+	loaded := make(map[string]bool)
+	var insmod func(module string) error
+	insmod = func(module string) error {
+		if loaded[module] {
+			return nil
+		}
+		loaded[module] = true
+		for _, dep := range deps[module] {
+			if err := insmod(dep); err != nil {
+				return err
+			}
+		}
+		if err := runCommand(ctx, "insmod", filepath.Join(path, module)); err != nil {
+			return errors.Wrapf(err, "insmod %s", module)
+		}
+		return nil
+	}
+
+	for module := range deps {
+		if err := insmod(module); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseModulesDep parses the `modules.dep` under `path`, a series of
+// `<module>.ko: <dep1>.ko <dep2>.ko ...` lines where every module path is
+// relative to `path`, into a map from module to its direct dependencies.
+func parseModulesDep(path string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, modulesDepFile))
+	if err != nil {
+		return nil, err
+	}
+
+	deps := make(map[string][]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("malformed modules.dep line: %q", line)
+		}
+		deps[strings.TrimSpace(parts[0])] = strings.Fields(parts[1])
+	}
+	return deps, nil
+}
+
+func runCommand(ctx context.Context, name string, args ...string) error {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "%s", string(out))
+	}
+	log.G(ctx).WithField("output", string(out)).Debugf("ran %s", name)
+	return nil
+}
+
+type closer struct {
+	attachment *scsi.Attachment
+	mount      *scsi.Mount
+}
+
+func (c *closer) Close(ctx context.Context) error {
+	if err := c.mount.Close(ctx); err != nil {
+		return err
+	}
+	return c.attachment.Close(ctx)
+}
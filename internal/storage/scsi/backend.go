@@ -0,0 +1,198 @@
+// +build linux
+
+package scsi
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Microsoft/opengcs/internal/log"
+	"github.com/Microsoft/opengcs/internal/oc"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	"golang.org/x/sys/unix"
+)
+
+// backend is the low-level interface the scsi package builds `Manager` on
+// top of. It exists so that `Manager` can be unit tested without touching
+// `/sys/bus/scsi` or issuing real mount syscalls.
+type backend interface {
+	// controllerLunToName resolves the `/dev/sd*` path for the SCSI device
+	// on `controller` index `lun`.
+	controllerLunToName(ctx context.Context, controller, lun uint8) (string, error)
+	// partitionDevicePath resolves the `/dev/sd<x><partition>` path for the
+	// `partition`'th (1-indexed) partition of the SCSI device on
+	// `controller` index `lun`.
+	partitionDevicePath(ctx context.Context, controller, lun uint8, devPath string, partition uint32) (string, error)
+	// unplugDevice issues a guest initiated unplug for the SCSI device on
+	// `controller` index `lun`.
+	unplugDevice(ctx context.Context, controller, lun uint8) error
+	// mount mkdir's `target` and mounts `source` onto it.
+	mount(ctx context.Context, source, target, fsType string, flags uintptr, data string) error
+	// unmount unmounts `target` and removes it.
+	unmount(ctx context.Context, target string) error
+}
+
+// osBackend is the production `backend` implementation, backed by the real
+// `/sys/bus/scsi` filesystem and `unix.Mount`/`unix.Unmount`.
+type osBackend struct{}
+
+var _ backend = &osBackend{}
+
+func (*osBackend) controllerLunToName(ctx context.Context, controller, lun uint8) (_ string, err error) {
+	ctx, span := trace.StartSpan(ctx, "scsi::controllerLunToName")
+	defer span.End()
+	defer func() { oc.SetSpanStatus(span, err) }()
+
+	span.AddAttributes(
+		trace.Int64Attribute("controller", int64(controller)),
+		trace.Int64Attribute("lun", int64(lun)))
+
+	scsiID := fmt.Sprintf("0:0:%d:%d", controller, lun)
+
+	// Devices matching the given SCSI code should each have a subdirectory
+	// under /sys/bus/scsi/devices/<scsiID>/block.
+	blockPath := filepath.Join("/sys/bus/scsi/devices", scsiID, "block")
+	var deviceNames []os.FileInfo
+	for {
+		deviceNames, err = ioutil.ReadDir(blockPath)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if len(deviceNames) == 0 {
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			default:
+				time.Sleep(time.Millisecond * 10)
+				continue
+			}
+		}
+		break
+	}
+
+	if len(deviceNames) == 0 {
+		return "", errors.Errorf("no matching device names found for SCSI ID \"%s\"", scsiID)
+	}
+	if len(deviceNames) > 1 {
+		return "", errors.Errorf("more than one block device could match SCSI ID \"%s\"", scsiID)
+	}
+
+	devicePath := filepath.Join("/dev", deviceNames[0].Name())
+	log.G(ctx).WithField("devicePath", devicePath).Debug("found device path")
+	return devicePath, nil
+}
+
+func (*osBackend) partitionDevicePath(ctx context.Context, controller, lun uint8, devPath string, partition uint32) (_ string, err error) {
+	ctx, span := trace.StartSpan(ctx, "scsi::partitionDevicePath")
+	defer span.End()
+	defer func() { oc.SetSpanStatus(span, err) }()
+
+	span.AddAttributes(
+		trace.Int64Attribute("controller", int64(controller)),
+		trace.Int64Attribute("lun", int64(lun)),
+		trace.Int64Attribute("partition", int64(partition)))
+
+	scsiID := fmt.Sprintf("0:0:%d:%d", controller, lun)
+	dev := filepath.Base(devPath)
+	partName := fmt.Sprintf("%s%d", dev, partition)
+	partPath := filepath.Join("/sys/bus/scsi/devices", scsiID, "block", dev, partName)
+
+	for {
+		if _, err := os.Stat(partPath); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		default:
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	return filepath.Join("/dev", partName), nil
+}
+
+func (*osBackend) unplugDevice(ctx context.Context, controller, lun uint8) (err error) {
+	_, span := trace.StartSpan(ctx, "scsi::unplugDevice")
+	defer span.End()
+	defer func() { oc.SetSpanStatus(span, err) }()
+
+	span.AddAttributes(
+		trace.Int64Attribute("controller", int64(controller)),
+		trace.Int64Attribute("lun", int64(lun)))
+
+	scsiID := fmt.Sprintf("0:0:%d:%d", controller, lun)
+	f, err := os.OpenFile(filepath.Join("/sys/bus/scsi/devices", scsiID, "delete"), os.O_WRONLY, 0644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte("1\n")); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (*osBackend) mount(ctx context.Context, source, target, fsType string, flags uintptr, data string) (err error) {
+	ctx, span := trace.StartSpan(ctx, "scsi::mount")
+	defer span.End()
+	defer func() { oc.SetSpanStatus(span, err) }()
+
+	span.AddAttributes(
+		trace.StringAttribute("source", source),
+		trace.StringAttribute("target", target),
+		trace.StringAttribute("fsType", fsType))
+
+	if err := os.MkdirAll(target, 0700); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(target)
+		}
+	}()
+
+	for {
+		if err := unix.Mount(source, target, fsType, flags, data); err != nil {
+			// The `source` found by controllerLunToName can take some time
+			// before its actually available under `/dev/sd*`. Retry while we
+			// wait for `source` to show up.
+			if err == unix.ENOENT {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+					time.Sleep(10 * time.Millisecond)
+					continue
+				}
+			}
+			return err
+		}
+		break
+	}
+	return nil
+}
+
+func (*osBackend) unmount(ctx context.Context, target string) (err error) {
+	_, span := trace.StartSpan(ctx, "scsi::unmount")
+	defer span.End()
+	defer func() { oc.SetSpanStatus(span, err) }()
+
+	span.AddAttributes(trace.StringAttribute("target", target))
+
+	if err := unix.Unmount(target, 0); err != nil {
+		return err
+	}
+	return os.RemoveAll(target)
+}
@@ -0,0 +1,159 @@
+// +build linux
+
+package scsi
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Microsoft/opengcs/internal/oc"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// controllerLun identifies a SCSI device by its controller/LUN pair.
+type controllerLun struct {
+	controller uint8
+	lun        uint8
+}
+
+// Manager tracks the SCSI devices currently attached to the guest, and the
+// filesystem mounts derived from them.
+//
+// Attaching and mounting are independent, refcounted operations: `Attach`
+// may be called more than once for the same controller/LUN, and the
+// resulting `Attachment` may in turn be `Mount`ed more than once, for
+// example at different targets, or for different partitions of the same
+// disk. The underlying device is only unplugged once every `Attachment`
+// handle has been closed, and a mount is only torn down once every handle
+// returned for it has been closed.
+type Manager struct {
+	attach *attachManager
+}
+
+// NewManager returns a `Manager` backed by the real `/sys/bus/scsi`
+// filesystem and `unix.Mount`.
+func NewManager() *Manager {
+	return newManager(&osBackend{})
+}
+
+func newManager(b backend) *Manager {
+	return &Manager{attach: newAttachManager(b)}
+}
+
+// Attach returns a handle to the SCSI device on `controller` index `lun`,
+// attaching it if this is the first outstanding reference.
+func (m *Manager) Attach(ctx context.Context, controller, lun uint8) (_ *Attachment, err error) {
+	ctx, span := trace.StartSpan(ctx, "scsi::Manager::Attach")
+	defer span.End()
+	defer func() { oc.SetSpanStatus(span, err) }()
+
+	span.AddAttributes(
+		trace.Int64Attribute("controller", int64(controller)),
+		trace.Int64Attribute("lun", int64(lun)))
+
+	return m.attach.get(ctx, controller, lun)
+}
+
+// attachManager hands out refcounted `Attachment`s for a controller/LUN
+// pair, unplugging the backing device when the last reference is released.
+type attachManager struct {
+	backend backend
+
+	mu      sync.Mutex
+	entries map[controllerLun]*Attachment
+}
+
+func newAttachManager(b backend) *attachManager {
+	return &attachManager{backend: b, entries: make(map[controllerLun]*Attachment)}
+}
+
+func (am *attachManager) get(ctx context.Context, controller, lun uint8) (*Attachment, error) {
+	key := controllerLun{controller, lun}
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	if a, ok := am.entries[key]; ok {
+		a.refs++
+		return a, nil
+	}
+
+	devPath, err := am.backend.controllerLunToName(ctx, controller, lun)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Attachment{
+		manager:    am,
+		mount:      newMountManager(am.backend),
+		controller: controller,
+		lun:        lun,
+		devPath:    devPath,
+		refs:       1,
+	}
+	am.entries[key] = a
+	return a, nil
+}
+
+func (am *attachManager) release(ctx context.Context, a *Attachment) error {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	// Only the caller releasing the very last reference actually unplugs
+	// the device, so only that caller needs to care whether mounts are
+	// still outstanding. Earlier releases must not be blocked by mounts
+	// held through a different `Attachment` reference.
+	if a.refs == 1 && a.mount.count() > 0 {
+		return errors.Errorf("cannot close last reference to attachment for controller %d lun %d with outstanding mounts", a.controller, a.lun)
+	}
+
+	a.refs--
+	if a.refs > 0 {
+		return nil
+	}
+	delete(am.entries, controllerLun{a.controller, a.lun})
+	return am.backend.unplugDevice(ctx, a.controller, a.lun)
+}
+
+// Attachment is a handle to a SCSI device attached to the guest on a given
+// controller/LUN. It is obtained from `Manager.Attach` and must be closed
+// once the caller no longer needs the device.
+type Attachment struct {
+	manager *attachManager
+	mount   *mountManager
+
+	controller uint8
+	lun        uint8
+	devPath    string
+
+	refs int
+}
+
+// Mount returns a handle to a filesystem mount derived from this
+// attachment, mounting it if this is the first outstanding reference for
+// `target`.
+func (a *Attachment) Mount(ctx context.Context, target string, cfg MountConfig) (_ *Mount, err error) {
+	ctx, span := trace.StartSpan(ctx, "scsi::Attachment::Mount")
+	defer span.End()
+	defer func() { oc.SetSpanStatus(span, err) }()
+
+	span.AddAttributes(
+		trace.StringAttribute("devPath", a.devPath),
+		trace.StringAttribute("target", target))
+
+	return a.mount.get(ctx, a.controller, a.lun, a.devPath, target, cfg)
+}
+
+// Close releases a reference on the attachment. Once the last reference is
+// released the backing device is unplugged.
+//
+// It is an error to release the last reference while the attachment still
+// has outstanding `Mount`s.
+func (a *Attachment) Close(ctx context.Context) (err error) {
+	ctx, span := trace.StartSpan(ctx, "scsi::Attachment::Close")
+	defer span.End()
+	defer func() { oc.SetSpanStatus(span, err) }()
+
+	return a.manager.release(ctx, a)
+}
@@ -0,0 +1,183 @@
+// +build linux
+
+package scsi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// fakeBackend is an in-memory `backend` used to unit test `Manager` without
+// touching `/sys/bus/scsi` or issuing real mount syscalls.
+type fakeBackend struct {
+	unplugged []controllerLun
+	mounted   map[string]string // target -> source
+	unmounted []string
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{mounted: make(map[string]string)}
+}
+
+func (f *fakeBackend) controllerLunToName(_ context.Context, controller, lun uint8) (string, error) {
+	return fmt.Sprintf("/dev/sd%d%d", controller, lun), nil
+}
+
+func (f *fakeBackend) partitionDevicePath(_ context.Context, _, _ uint8, devPath string, partition uint32) (string, error) {
+	return fmt.Sprintf("%s%d", devPath, partition), nil
+}
+
+func (f *fakeBackend) unplugDevice(_ context.Context, controller, lun uint8) error {
+	f.unplugged = append(f.unplugged, controllerLun{controller, lun})
+	return nil
+}
+
+func (f *fakeBackend) mount(_ context.Context, source, target, _ string, _ uintptr, _ string) error {
+	f.mounted[target] = source
+	return nil
+}
+
+func (f *fakeBackend) unmount(_ context.Context, target string) error {
+	f.unmounted = append(f.unmounted, target)
+	delete(f.mounted, target)
+	return nil
+}
+
+func TestManagerAttachRefcounts(t *testing.T) {
+	ctx := context.Background()
+	fb := newFakeBackend()
+	m := newManager(fb)
+
+	a1, err := m.Attach(ctx, 0, 1)
+	if err != nil {
+		t.Fatalf("first Attach failed: %v", err)
+	}
+	a2, err := m.Attach(ctx, 0, 1)
+	if err != nil {
+		t.Fatalf("second Attach failed: %v", err)
+	}
+	if a1 != a2 {
+		t.Fatalf("expected the same Attachment to be returned for repeated Attach calls")
+	}
+	if a1.refs != 2 {
+		t.Fatalf("expected refs == 2, got %d", a1.refs)
+	}
+
+	if err := a1.Close(ctx); err != nil {
+		t.Fatalf("first Close failed: %v", err)
+	}
+	if len(fb.unplugged) != 0 {
+		t.Fatalf("device should not be unplugged while a reference remains")
+	}
+
+	if err := a2.Close(ctx); err != nil {
+		t.Fatalf("second Close failed: %v", err)
+	}
+	if len(fb.unplugged) != 1 || fb.unplugged[0] != (controllerLun{0, 1}) {
+		t.Fatalf("expected device to be unplugged once the last reference was released, got %v", fb.unplugged)
+	}
+}
+
+func TestManagerAttachDistinctLuns(t *testing.T) {
+	ctx := context.Background()
+	fb := newFakeBackend()
+	m := newManager(fb)
+
+	a, err := m.Attach(ctx, 0, 1)
+	if err != nil {
+		t.Fatalf("Attach(0,1) failed: %v", err)
+	}
+	b, err := m.Attach(ctx, 0, 2)
+	if err != nil {
+		t.Fatalf("Attach(0,2) failed: %v", err)
+	}
+	if a == b {
+		t.Fatalf("expected distinct Attachments for distinct controller/LUN pairs")
+	}
+}
+
+func TestAttachmentMountRefcounts(t *testing.T) {
+	ctx := context.Background()
+	fb := newFakeBackend()
+	m := newManager(fb)
+
+	a, err := m.Attach(ctx, 0, 1)
+	if err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+
+	mnt1, err := a.Mount(ctx, "/mnt/x", MountConfig{})
+	if err != nil {
+		t.Fatalf("first Mount failed: %v", err)
+	}
+	mnt2, err := a.Mount(ctx, "/mnt/x", MountConfig{})
+	if err != nil {
+		t.Fatalf("second Mount failed: %v", err)
+	}
+	if mnt1 != mnt2 {
+		t.Fatalf("expected the same Mount to be returned for the same target")
+	}
+	if _, ok := fb.mounted["/mnt/x"]; !ok {
+		t.Fatalf("expected target to be mounted")
+	}
+
+	if err := mnt1.Close(ctx); err != nil {
+		t.Fatalf("first mount Close failed: %v", err)
+	}
+	if len(fb.unmounted) != 0 {
+		t.Fatalf("target should not be unmounted while a reference remains")
+	}
+
+	if err := mnt2.Close(ctx); err != nil {
+		t.Fatalf("second mount Close failed: %v", err)
+	}
+	if len(fb.unmounted) != 1 || fb.unmounted[0] != "/mnt/x" {
+		t.Fatalf("expected target to be unmounted once the last reference was released, got %v", fb.unmounted)
+	}
+}
+
+func TestAttachmentCloseBlockedByOutstandingMount(t *testing.T) {
+	ctx := context.Background()
+	fb := newFakeBackend()
+	m := newManager(fb)
+
+	a, err := m.Attach(ctx, 0, 1)
+	if err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	if _, err := a.Mount(ctx, "/mnt/x", MountConfig{}); err != nil {
+		t.Fatalf("Mount failed: %v", err)
+	}
+
+	if err := a.Close(ctx); err == nil {
+		t.Fatalf("expected closing the last attachment reference with an outstanding mount to fail")
+	}
+	if len(fb.unplugged) != 0 {
+		t.Fatalf("device should not have been unplugged")
+	}
+}
+
+func TestAttachmentCloseNotBlockedByOtherHoldersMount(t *testing.T) {
+	ctx := context.Background()
+	fb := newFakeBackend()
+	m := newManager(fb)
+
+	a1, err := m.Attach(ctx, 0, 1)
+	if err != nil {
+		t.Fatalf("first Attach failed: %v", err)
+	}
+	a2, err := m.Attach(ctx, 0, 1)
+	if err != nil {
+		t.Fatalf("second Attach failed: %v", err)
+	}
+	if _, err := a1.Mount(ctx, "/mnt/x", MountConfig{}); err != nil {
+		t.Fatalf("Mount failed: %v", err)
+	}
+
+	// a2 is not the last reference, so it must be free to close even though
+	// a1 still has a mount open.
+	if err := a2.Close(ctx); err != nil {
+		t.Fatalf("expected non-last Close to succeed despite another holder's open mount: %v", err)
+	}
+}
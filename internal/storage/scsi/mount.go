@@ -0,0 +1,173 @@
+// +build linux
+
+package scsi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Microsoft/opengcs/internal/oc"
+	"go.opencensus.io/trace"
+	"golang.org/x/sys/unix"
+)
+
+// MountConfig describes how an `Attachment` should be mounted.
+type MountConfig struct {
+	// FSType is the filesystem type to mount as, e.g. "ext4", "xfs",
+	// "vfat", or "overlay". Defaults to "ext4" if empty.
+	FSType string
+	// ReadOnly requests the mount be made read-only. The data passed to
+	// mount(2) to achieve this is filesystem specific (e.g. `ext4` needs
+	// "noload" to skip replaying its journal, `xfs` needs "norecovery").
+	ReadOnly bool
+	// Options is a comma-separated list of additional mount options,
+	// appended after any options `ReadOnly` implies.
+	Options string
+	// Partition requests the mount be of the Nth (1-indexed) partition of
+	// the attached disk, rather than the whole disk. 0 means the whole
+	// disk.
+	Partition uint32
+	// Verity, if set, requests the mount source be integrity-checked via
+	// dm-verity before being mounted. The resulting mount is always
+	// read-only, regardless of `ReadOnly`.
+	Verity *VerityConfig
+}
+
+// readOnlyMountData returns the fstype-specific data needed to mount
+// read-only without failing on an unclean journal/log.
+func readOnlyMountData(fsType string) string {
+	switch fsType {
+	case "ext4", "ext3":
+		return "noload"
+	case "xfs":
+		return "norecovery"
+	default:
+		return ""
+	}
+}
+
+// mountManager hands out refcounted `Mount`s for a given target path,
+// unmounting when the last reference for that target is released.
+type mountManager struct {
+	backend backend
+
+	mu      sync.Mutex
+	entries map[string]*Mount
+}
+
+func newMountManager(b backend) *mountManager {
+	return &mountManager{backend: b, entries: make(map[string]*Mount)}
+}
+
+func (mm *mountManager) count() int {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	return len(mm.entries)
+}
+
+func (mm *mountManager) get(ctx context.Context, controller, lun uint8, devPath, target string, cfg MountConfig) (*Mount, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if m, ok := mm.entries[target]; ok {
+		m.refs++
+		return m, nil
+	}
+
+	source := devPath
+	if cfg.Partition != 0 {
+		p, err := mm.backend.partitionDevicePath(ctx, controller, lun, devPath, cfg.Partition)
+		if err != nil {
+			return nil, err
+		}
+		source = p
+	}
+
+	readOnly := cfg.ReadOnly
+	var verityName string
+	if cfg.Verity != nil {
+		readOnly = true
+		verityName = fmt.Sprintf("opengcs-verity-%d-%d-%d", controller, lun, cfg.Partition)
+		mapperPath, err := createVerityDevice(ctx, verityName, source, *cfg.Verity)
+		if err != nil {
+			return nil, err
+		}
+		source = mapperPath
+	}
+
+	fsType := cfg.FSType
+	if fsType == "" {
+		fsType = "ext4"
+	}
+
+	var flags uintptr
+	var data []string
+	if readOnly {
+		flags |= unix.MS_RDONLY
+		if d := readOnlyMountData(fsType); d != "" {
+			data = append(data, d)
+		}
+	}
+	if cfg.Options != "" {
+		data = append(data, cfg.Options)
+	}
+
+	if err := mm.backend.mount(ctx, source, target, fsType, flags, strings.Join(data, ",")); err != nil {
+		if verityName != "" {
+			removeVerityDevice(ctx, verityName)
+		}
+		return nil, err
+	}
+
+	m := &Mount{manager: mm, target: target, verityName: verityName, refs: 1}
+	mm.entries[target] = m
+	return m, nil
+}
+
+func (mm *mountManager) release(ctx context.Context, m *Mount) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	m.refs--
+	if m.refs > 0 {
+		return nil
+	}
+	delete(mm.entries, m.target)
+	if err := mm.backend.unmount(ctx, m.target); err != nil {
+		return err
+	}
+	if m.verityName != "" {
+		return removeVerityDevice(ctx, m.verityName)
+	}
+	return nil
+}
+
+// Mount is a handle to a filesystem view derived from an `Attachment`. It is
+// obtained from `Attachment.Mount` and must be closed once the caller no
+// longer needs the filesystem view.
+type Mount struct {
+	manager    *mountManager
+	target     string
+	verityName string
+
+	refs int
+}
+
+// Target is the path this mount is visible at.
+func (m *Mount) Target() string {
+	return m.target
+}
+
+// Close releases a reference on the mount. Once the last reference is
+// released the mount is torn down.
+func (m *Mount) Close(ctx context.Context) (err error) {
+	ctx, span := trace.StartSpan(ctx, "scsi::Mount::Close")
+	defer span.End()
+	defer func() { oc.SetSpanStatus(span, err) }()
+
+	span.AddAttributes(trace.StringAttribute("target", m.target))
+
+	return m.manager.release(ctx, m)
+}
@@ -0,0 +1,250 @@
+// +build linux
+
+package scsi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/Microsoft/opengcs/internal/log"
+	"github.com/Microsoft/opengcs/internal/oc"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	"golang.org/x/sys/unix"
+)
+
+// VerityConfig describes the dm-verity parameters needed to mount an
+// integrity-protected disk image read-only through a `/dev/mapper/<name>`
+// device, rather than the raw `/dev/sd*` block device.
+type VerityConfig struct {
+	// RootHash is the hex-encoded root hash of the verity hash tree.
+	RootHash string
+	// Salt is the hex-encoded salt used when the hash tree was built.
+	Salt string
+	// Algorithm is the hash algorithm used to build the tree, e.g.
+	// "sha256".
+	Algorithm string
+	// DataBlockSize and HashBlockSize are the block sizes, in bytes, of
+	// the data and hash devices respectively.
+	DataBlockSize uint32
+	HashBlockSize uint32
+	// DataBlocks is the number of data blocks on the data device covered
+	// by the hash tree.
+	DataBlocks uint64
+	// HashStartBlock is the block, on the hash device, that the hash tree
+	// begins at.
+	HashStartBlock uint64
+	// HashDevPath is the `/dev/sd*` path of the device holding the hash
+	// tree. If empty, the hash tree is assumed to live on the data device
+	// itself, at `HashStartBlock`.
+	HashDevPath string
+}
+
+// dm-ioctl.h constants. `dmIoctlVersion` matches DM_VERSION_MAJOR.0.0, the
+// oldest ABI that supports everything used below.
+const (
+	dmDir          = "/dev/mapper"
+	dmControlPath  = "/dev/mapper/control"
+	dmIoctlVersion = 4
+	dmNameLen      = 128
+	dmUUIDLen      = 129
+)
+
+// dmIoctl mirrors struct dm_ioctl from <linux/dm-ioctl.h>. Not every named
+// field is used by this package; the `_padding` fields round out the
+// kernel's expected struct layout.
+type dmIoctl struct {
+	version     [3]uint32
+	dataSize    uint32
+	dataStart   uint32
+	targetCount uint32
+	openCount   int32
+	flags       uint32
+	eventNr     uint32
+	_padding    uint32
+	dev         uint64
+	name        [dmNameLen]byte
+	uuid        [dmUUIDLen]byte
+	_padding2   [7]byte
+	data        [16 * 1024]byte
+}
+
+const (
+	dmIoctlType    = 0xfd
+	dmDevCreateNr  = 3
+	dmDevRemoveNr  = 4
+	dmDevSuspendNr = 6
+	dmTableLoadNr  = 9
+
+	// dmMaxTypeName is the size of dm_target_spec.target_type.
+	dmMaxTypeName = 16
+)
+
+// dmTargetSpec mirrors struct dm_target_spec from <linux/dm-ioctl.h>. A
+// DM_TABLE_LOAD payload is one or more of these, each immediately followed
+// by its target's null-terminated parameter string.
+type dmTargetSpec struct {
+	sectorStart uint64
+	length      uint64
+	status      int32
+	next        uint32
+	targetType  [dmMaxTypeName]byte
+}
+
+func dmIOWR(nr uintptr, size uintptr) uintptr {
+	const iocRW = 3 << 30
+	return iocRW | (size << 16) | (dmIoctlType << 8) | nr
+}
+
+func newDMIoctl(name string) *dmIoctl {
+	var d dmIoctl
+	d.version = [3]uint32{dmIoctlVersion, 0, 0}
+	d.dataStart = uint32(unsafe.Offsetof(dmIoctl{}.data))
+	// The kernel's copy_params() rejects any dm_ioctl whose data_size is
+	// smaller than the header (i.e. doesn't even cover dataStart). Default
+	// to the full struct size; callers appending a payload into `data` set
+	// it to cover just the header and that payload instead.
+	d.dataSize = uint32(unsafe.Sizeof(dmIoctl{}))
+	copy(d.name[:], name)
+	return &d
+}
+
+func dmIoctlCall(fd uintptr, nr uintptr, d *dmIoctl) error {
+	cmd := dmIOWR(nr, unsafe.Sizeof(dmIoctl{}))
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, cmd, uintptr(unsafe.Pointer(d))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// dmDevtMajorMinor decodes the "huge" dev_t encoding the kernel returns
+// DM_DEV_CREATE's new device number in (see <linux/kdev_t.h>).
+func dmDevtMajorMinor(dev uint64) (uint32, uint32) {
+	major := uint32((dev>>8)&0xfff) | uint32((dev>>32)&0xfffff000)
+	minor := uint32(dev&0xff) | uint32((dev>>12)&0xffffff00)
+	return major, minor
+}
+
+// createDeviceNode creates the block device special file for `dev` at
+// `path`, so the caller does not depend on udev populating `/dev/mapper`.
+func createDeviceNode(path string, dev uint64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	os.Remove(path)
+	major, minor := dmDevtMajorMinor(dev)
+	return unix.Mknod(path, unix.S_IFBLK|0600, int(unix.Mkdev(major, minor)))
+}
+
+// createVerityDevice sets up a dm-verity target over `dataDevPath` using the
+// parameters in `cfg`, and returns the resulting `/dev/mapper/<name>` path.
+func createVerityDevice(ctx context.Context, name, dataDevPath string, cfg VerityConfig) (_ string, err error) {
+	ctx, span := trace.StartSpan(ctx, "scsi::createVerityDevice")
+	defer span.End()
+	defer func() { oc.SetSpanStatus(span, err) }()
+
+	span.AddAttributes(trace.StringAttribute("name", name), trace.StringAttribute("dataDevPath", dataDevPath))
+
+	hashDevPath := cfg.HashDevPath
+	if hashDevPath == "" {
+		hashDevPath = dataDevPath
+	}
+
+	f, err := os.OpenFile(dmControlPath, os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.Wrap(err, "opening /dev/mapper/control")
+	}
+	defer f.Close()
+	fd := f.Fd()
+
+	d := newDMIoctl(name)
+	if err := dmIoctlCall(fd, dmDevCreateNr, d); err != nil {
+		return "", errors.Wrapf(err, "DM_DEV_CREATE for %s", name)
+	}
+	defer func() {
+		if err != nil {
+			removeDevice(ctx, fd, name)
+		}
+	}()
+
+	// The verity target's parameter string starts with the target version,
+	// not the target type - the type goes in dm_target_spec.target_type.
+	params := fmt.Sprintf(
+		"1 %s %s %d %d %d %d %s %s %s",
+		dataDevPath, hashDevPath,
+		cfg.DataBlockSize, cfg.HashBlockSize,
+		cfg.DataBlocks, cfg.HashStartBlock,
+		cfg.Algorithm, cfg.RootHash, cfg.Salt)
+	params += "\x00"
+
+	spec := dmTargetSpec{
+		sectorStart: 0,
+		length:      cfg.DataBlocks * uint64(cfg.DataBlockSize) / 512,
+	}
+	copy(spec.targetType[:], "verity")
+
+	specBuf := &bytes.Buffer{}
+	if err := binary.Write(specBuf, binary.LittleEndian, spec); err != nil {
+		return "", errors.Wrap(err, "encoding dm_target_spec")
+	}
+
+	load := newDMIoctl(name)
+	load.targetCount = 1
+	payload := append(specBuf.Bytes(), []byte(params)...)
+	if len(payload) > len(load.data) {
+		return "", errors.Errorf("verity table too large: %d bytes", len(payload))
+	}
+	copy(load.data[:], payload)
+	load.dataSize = load.dataStart + uint32(len(payload))
+	if err := dmIoctlCall(fd, dmTableLoadNr, load); err != nil {
+		return "", errors.Wrapf(err, "DM_TABLE_LOAD for %s", name)
+	}
+
+	suspend := newDMIoctl(name)
+	if err := dmIoctlCall(fd, dmDevSuspendNr, suspend); err != nil {
+		return "", errors.Wrapf(err, "DM_DEV_SUSPEND (resume) for %s", name)
+	}
+
+	// Create the device node ourselves from the dev_t DM_DEV_CREATE
+	// returned, rather than waiting on udev to populate /dev/mapper - the
+	// guest may not be running a udev that does so.
+	mapperPath := dmDir + "/" + name
+	if err := createDeviceNode(mapperPath, d.dev); err != nil {
+		return "", errors.Wrapf(err, "creating device node for %s", name)
+	}
+
+	log.G(ctx).WithField("mapperPath", mapperPath).Debug("created verity device")
+	return mapperPath, nil
+}
+
+// removeVerityDevice tears down the verity target created by
+// `createVerityDevice`.
+func removeVerityDevice(ctx context.Context, name string) (err error) {
+	ctx, span := trace.StartSpan(ctx, "scsi::removeVerityDevice")
+	defer span.End()
+	defer func() { oc.SetSpanStatus(span, err) }()
+
+	span.AddAttributes(trace.StringAttribute("name", name))
+
+	f, err := os.OpenFile(dmControlPath, os.O_RDWR, 0)
+	if err != nil {
+		return errors.Wrap(err, "opening /dev/mapper/control")
+	}
+	defer f.Close()
+
+	return removeDevice(ctx, f.Fd(), name)
+}
+
+func removeDevice(ctx context.Context, fd uintptr, name string) error {
+	d := newDMIoctl(name)
+	if err := dmIoctlCall(fd, dmDevRemoveNr, d); err != nil {
+		return errors.Wrapf(err, "DM_DEV_REMOVE for %s", name)
+	}
+	os.Remove(dmDir + "/" + name)
+	return nil
+}